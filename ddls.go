@@ -1,10 +1,12 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/pkg/errors"
+
+	"github.com/richardwu/roach-bench/workload"
 )
 
 // schemaVariant is the type of schema we want to benchmark on. Tables are
@@ -14,91 +16,36 @@ type schemaVariant string
 const (
 	Normal      schemaVariant = "normal"
 	Interleaved               = "interleaved"
+	// Deferred tables carry the same FK topology as Normal, but the FK
+	// constraints are declared inline in CREATE TABLE as DEFERRABLE
+	// INITIALLY DEFERRED rather than added after the load via
+	// applyConstraints.
+	Deferred = "deferred"
 )
 
 type tableName string
 
-const (
-	Merchant           tableName = "merchant"
-	Product                      = "product"
-	Variant                      = "variant"
-	Store                        = "store"
-	ProductInterleaved           = "product (interleaved)"
-	VariantInterleaved           = "variant (interleaved)"
-	StoreInterleaved             = "store (interleaved)"
-)
+// interleaveName maps a table to its interleaved-schema counterpart
+// (e.g. "product" -> "product (interleaved)"), populated from spec.Tables
+// that declare an Interleave in initDDL.
+var interleaveName = make(map[tableName]tableName)
 
-var interleaveName = map[tableName]tableName{
-	Product: ProductInterleaved,
-	Variant: VariantInterleaved,
-	Store:   StoreInterleaved,
-}
+// deferredName maps a table to its deferred-FK-schema counterpart (e.g.
+// "product" -> "product (deferred)"), populated for every table in
+// spec.Tables in initDDL. Only tables that declare an Interleave (which
+// also doubles as the FK topology: a table references its interleave
+// parent's primary key) get an inline FK clause in their deferred DDL.
+var deferredName = make(map[tableName]tableName)
 
-// Map of all DDL statements that are run when loadSchema is invoked.
-var ddlStmts = map[tableName]string{
-	Merchant: `
-	create table merchant (
-	  m_id		integer	      not null,
-	  m_name	text,
-	  m_address	text,
-	  primary key (m_id)
-	)`,
-
-	Product: `
-	create table product (
-	  p_m_id	integer	      not null,
-	  p_id		integer	      not null,
-	  p_name	text,
-	  p_desc	text,
-	  primary key (p_m_id, p_id)
-	)`,
-	// foreign key (p_m_id) references merchant (m_id)
-
-	Variant: `
-	create table variant (
-	  v_m_id	integer	      not null,
-	  v_p_id	integer	      not null,
-	  v_id		integer	      not null,
-	  v_name	text,
-	  v_qty		integer,
-	  v_price	decimal,
-	  primary key (v_m_id, v_p_id, v_id)
-	)`,
-	// foreign key (v_m_id, v_p_id) references product (p_m_id, p_id)
-
-	Store: `
-	create table store (
-	  s_m_id	integer	      not null,
-	  s_id		integer	      not null,
-	  s_name	text,
-	  s_address	text,
-	  primary key (s_m_id, s_id)
-	)`,
-	// foreign key(s_m_id) references merchant (m_id)
-}
+// tablePKColumns gives each table's primary key column names in order,
+// needed to render a child table's "references parent (...)" clause.
+var tablePKColumns = make(map[tableName][]string)
 
-var insertStmts = map[tableName]string{
-	Merchant: `
-  insert into merchant
-  (m_id, m_name, m_address)
-  values
-  `,
-	Product: `
-  insert into product
-  (p_m_id, p_id, p_name, p_desc)
-  values
-  `,
-	Variant: `
-  insert into variant
-  (v_m_id, v_p_id, v_id, v_name, v_qty, v_price)
-  values
-  `,
-	Store: `
-  insert into store
-  (s_m_id, s_id, s_name, s_address)
-  values
-  `,
-}
+// Map of all DDL statements that are run when loadSchema is invoked,
+// built in initDDL from the loaded workload.Spec.
+var ddlStmts = make(map[tableName]string)
+
+var insertStmts = make(map[tableName]string)
 
 type columnType int
 
@@ -110,90 +57,378 @@ const (
 	FkeyInt
 )
 
-var tableTypes = map[tableName][]columnType{
-	Merchant: []columnType{PkeyInt, Text, Text},
-	Product:  []columnType{FkeyInt, PkeyInt, Text, Text},
-	Variant:  []columnType{FkeyInt, FkeyInt, PkeyInt, Text, Int, Dec},
-	Store:    []columnType{FkeyInt, PkeyInt, Text, Text},
-}
+// tableTypes gives each table's column types in order, used by the
+// generator to dispatch on how to produce each column's value.
+var tableTypes = make(map[tableName][]columnType)
+
+// tableColumns gives each table's column names in insertStmts' order,
+// used by the --loader=copy path (pq.CopyIn / pgx CopyFrom), which needs
+// column names rather than a pre-built "insert ... values" clause.
+var tableColumns = make(map[tableName][]string)
+
+// tableSQLName gives the real SQL relation name for a (possibly
+// variant-suffixed) tableName -- e.g. both "product" and
+// "product (interleaved)" map to "product", since the " (interleaved)"/
+// " (deferred)" suffix only disambiguates Go-side maps and is never part
+// of the CREATE TABLE name itself. Used by the --loader=copy path, which
+// talks to the database by relation name rather than through insertStmts.
+var tableSQLName = make(map[tableName]string)
+
+// tableTextLengths gives each table's per-column text length (0 for
+// non-Text columns), taken from the workload spec's TextLength -- the
+// generator's counterpart to sqlColumnType's varchar(n) DDL.
+var tableTextLengths = make(map[tableName][]int)
+
+// tableFkeyRefs gives, for each FkeyInt column (by index within the
+// table), the table its value is drawn from -- the fkey topology that
+// used to be a hardcoded switch in randFkeyInt.
+var tableFkeyRefs = make(map[tableName]map[int]tableName)
 
 type interleaveInfo struct {
 	name           tableName
 	interleaveStmt string
 }
 
-var toInterleave = []interleaveInfo{
-	{
-		name:           Product,
-		interleaveStmt: " interleave in parent merchant (p_m_id)",
-	},
-	{
-		name:           Variant,
-		interleaveStmt: " interleave in parent product (v_m_id, v_p_id)",
-	},
-	{
-		name:           Store,
-		interleaveStmt: " interleave in parent merchant (s_m_id)",
-	},
+var toInterleave []interleaveInfo
+
+var variantTables = make(map[schemaVariant][]tableName)
+
+var tableRows = make(map[tableName]int)
+
+// workloadSpec is the spec loaded by initDDL, kept around so
+// applyConstraints can walk each table's Interleave (FK topology) after
+// the initial load.
+var workloadSpec *workload.Spec
+
+// columnTypeFor maps a workload.Column's declared type to the internal
+// columnType the generator dispatches on.
+func columnTypeFor(c workload.Column) columnType {
+	switch c.Type {
+	case workload.ColumnText:
+		return Text
+	case workload.ColumnInt:
+		return Int
+	case workload.ColumnDecimal:
+		return Dec
+	case workload.ColumnPKey:
+		return PkeyInt
+	case workload.ColumnFKey:
+		return FkeyInt
+	default:
+		panic(fmt.Sprintf("unknown workload column type %q", c.Type))
+	}
 }
 
-var variantTables = map[schemaVariant][]tableName{
-	Normal: []tableName{
-		Merchant,
-		Product,
-		Variant,
-		Store,
-	},
-	Interleaved: []tableName{
-		Merchant,
-		ProductInterleaved,
-		VariantInterleaved,
-		StoreInterleaved,
-	},
+// sqlColumnType maps a workload.Column to the SQL type name for the
+// active --dbms dialect. Postgres/CockroachDB accept untyped TEXT and
+// DECIMAL; MySQL/TiDB require a length/precision on both.
+func sqlColumnType(c workload.Column) string {
+	switch c.Type {
+	case workload.ColumnPKey, workload.ColumnFKey, workload.ColumnInt:
+		if mysqlDBMS() {
+			return "int"
+		}
+		return "integer"
+	case workload.ColumnText:
+		if mysqlDBMS() {
+			n := c.TextLength
+			if n == 0 {
+				n = 64
+			}
+			return fmt.Sprintf("varchar(%d)", n)
+		}
+		return "text"
+	case workload.ColumnDecimal:
+		if mysqlDBMS() {
+			return "decimal(10,2)"
+		}
+		return "decimal"
+	default:
+		panic(fmt.Sprintf("unknown workload column type %q", c.Type))
+	}
 }
 
-var tableRows = make(map[tableName]int)
+// buildCreateTable renders t's CREATE TABLE statement for the active
+// --dbms dialect. Every ColumnPKey/ColumnFKey column becomes part of the
+// (possibly composite) primary key, in column order -- the same layout
+// the original hardcoded schema used (e.g. variant's PK is
+// (v_m_id, v_p_id, v_id)). fkClause, if non-empty, is appended as an
+// inline constraint -- used by the Deferred variant, which declares FKs
+// at CREATE TABLE time instead of via a later applyConstraints call.
+func buildCreateTable(t workload.Table, fkClause string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "\n\tcreate table %s (\n", t.Name)
 
-func initDDL() {
-	// Initialize interleave DDL statements.
-	for _, info := range toInterleave {
-		ddlStmts[interleaveName[info.name]] = fmt.Sprintf("%s %s", ddlStmts[info.name], info.interleaveStmt)
+	var pkCols []string
+	for _, c := range t.Columns {
+		notNull := ""
+		if c.Type == workload.ColumnPKey || c.Type == workload.ColumnFKey {
+			notNull = " not null"
+			pkCols = append(pkCols, c.Name)
+		}
+		fmt.Fprintf(&sb, "\t  %s\t%s%s,\n", c.Name, sqlColumnType(c), notNull)
+	}
+	fmt.Fprintf(&sb, "\t  primary key (%s)", strings.Join(pkCols, ", "))
+	if fkClause != "" {
+		fmt.Fprintf(&sb, ",\n\t  %s", fkClause)
+	}
+	sb.WriteString("\n\t)")
+
+	return sb.String()
+}
+
+// buildForeignKeyClause renders the "foreign key (...) references
+// parent (...)" clause for t, derived from t.Interleave -- a table's
+// interleave parent doubles as its FK topology, matching the
+// "foreign key (p_m_id) references merchant (m_id)"-style comments the
+// original hardcoded schema left in place but never executed. Returns ""
+// if t has no parent. deferrable appends "deferrable initially
+// deferred", for the Deferred variant.
+func buildForeignKeyClause(t workload.Table, deferrable bool) string {
+	if t.Interleave == nil {
+		return ""
+	}
+	parent := tableName(t.Interleave.Parent)
+	clause := fmt.Sprintf("foreign key (%s) references %s (%s)",
+		strings.Join(t.Interleave.Columns, ", "), t.Interleave.Parent, strings.Join(tablePKColumns[parent], ", "))
+	if deferrable {
+		clause += " deferrable initially deferred"
 	}
+	return clause
+}
 
-	// Interleave tables have same column types.
-	for name, types := range tableTypes {
-		tableTypes[interleaveName[name]] = types
+// buildInsertPrefix renders the "insert into t (cols) values" clause
+// that genValues/genArgs/the worker loop append literal or parameterized
+// row values to.
+func buildInsertPrefix(t workload.Table) string {
+	cols := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		cols[i] = c.Name
 	}
+	return fmt.Sprintf("\n  insert into %s\n  (%s)\n  values\n  ", t.Name, strings.Join(cols, ", "))
+}
+
+// initDDL derives every schema-dependent map (ddlStmts, insertStmts,
+// tableTypes, tableColumns, tableFkeyRefs, tableRows, variantTables) from
+// the workload.Spec loaded from --workload, replacing what used to be
+// Go-literal schema, row-count, and fkey-topology definitions.
+func initDDL(spec *workload.Spec) {
+	workloadSpec = spec
+
+	var normalTables []tableName
+
+	// First pass: collect every table's column layout, row count, and
+	// fkey/PK topology, so buildForeignKeyClause can look up a parent's
+	// primary key columns regardless of declaration order.
+	for _, t := range spec.Tables {
+		name := tableName(t.Name)
+		normalTables = append(normalTables, name)
+
+		tableRows[name] = t.Rows
+
+		types := make([]columnType, len(t.Columns))
+		cols := make([]string, len(t.Columns))
+		textLens := make([]int, len(t.Columns))
+		fkeyRefs := make(map[int]tableName)
+		var pkCols []string
+		for i, c := range t.Columns {
+			types[i] = columnTypeFor(c)
+			cols[i] = c.Name
+			if c.Type == workload.ColumnText {
+				n := c.TextLength
+				if n == 0 {
+					n = textLen
+				}
+				textLens[i] = n
+			}
+			if c.Type == workload.ColumnFKey {
+				fkeyRefs[i] = tableName(c.References)
+			}
+			if c.Type == workload.ColumnPKey || c.Type == workload.ColumnFKey {
+				pkCols = append(pkCols, c.Name)
+			}
+		}
+		tableTypes[name] = types
+		tableColumns[name] = cols
+		tableTextLengths[name] = textLens
+		tableFkeyRefs[name] = fkeyRefs
+		tablePKColumns[name] = pkCols
+		tableSQLName[name] = t.Name
 
-	// Interleave tables have the same insert statements.
-	for name, stmt := range insertStmts {
-		insertStmts[interleaveName[name]] = stmt
+		insertStmts[name] = buildInsertPrefix(t)
 	}
 
-	tableRows[Merchant] = *nMerchants
-	tableRows[Product] = *nProducts
-	tableRows[Variant] = *nVariants
-	tableRows[Store] = *nStores
+	// Second pass: build each variant's DDL now that every table's PK
+	// columns are known.
+	for _, t := range spec.Tables {
+		name := tableName(t.Name)
 
-	// Interleave tables have the same number of rows.
-	for name, n := range tableRows {
-		tableRows[interleaveName[name]] = n
+		ddlStmts[name] = buildCreateTable(t, "")
+
+		dname := tableName(fmt.Sprintf("%s (deferred)", t.Name))
+		deferredName[name] = dname
+		ddlStmts[dname] = buildCreateTable(t, buildForeignKeyClause(t, true))
+		tableTypes[dname] = tableTypes[name]
+		tableColumns[dname] = tableColumns[name]
+		tableTextLengths[dname] = tableTextLengths[name]
+		tableFkeyRefs[dname] = tableFkeyRefs[name]
+		tableRows[dname] = t.Rows
+		insertStmts[dname] = insertStmts[name]
+		tableSQLName[dname] = t.Name
+
+		if t.Interleave != nil {
+			iname := tableName(fmt.Sprintf("%s (interleaved)", t.Name))
+			interleaveName[name] = iname
+
+			toInterleave = append(toInterleave, interleaveInfo{
+				name: name,
+				interleaveStmt: fmt.Sprintf(" interleave in parent %s (%s)",
+					t.Interleave.Parent, strings.Join(t.Interleave.Columns, ", ")),
+			})
+
+			// Interleave tables share the normal table's column layout.
+			tableTypes[iname] = tableTypes[name]
+			tableColumns[iname] = tableColumns[name]
+			tableTextLengths[iname] = tableTextLengths[name]
+			tableFkeyRefs[iname] = tableFkeyRefs[name]
+			tableRows[iname] = t.Rows
+			insertStmts[iname] = insertStmts[name]
+			tableSQLName[iname] = t.Name
+		}
+	}
+
+	// Build interleave DDL statements.
+	for _, info := range toInterleave {
+		ddlStmts[interleaveName[info.name]] = fmt.Sprintf("%s %s", ddlStmts[info.name], info.interleaveStmt)
 	}
 
 	// Append semicolons to DDL statements.
 	for name, stmt := range ddlStmts {
-		// Append semicolon to all DDL statements.
 		ddlStmts[name] = fmt.Sprintf("%s;", stmt)
 	}
+
+	interleavedTables := make([]tableName, len(normalTables))
+	deferredTables := make([]tableName, len(normalTables))
+	for i, name := range normalTables {
+		if iname, ok := interleaveName[name]; ok {
+			interleavedTables[i] = iname
+		} else {
+			interleavedTables[i] = name
+		}
+		deferredTables[i] = deferredName[name]
+	}
+
+	variantTables[Normal] = normalTables
+	variantTables[Interleaved] = interleavedTables
+	variantTables[Deferred] = deferredTables
+}
+
+// variantTableName resolves a spec-declared (bare) table name -- e.g.
+// the "variant" a workload.Query names -- to the name the active
+// --variant's schema actually uses (e.g. "variant (interleaved)"),
+// mirroring the lookup generateData/newPgxPool get for free by ranging
+// over variantTables instead of spec.Tables directly.
+func variantTableName(t tableName) tableName {
+	switch schemaVar {
+	case Interleaved:
+		if iname, ok := interleaveName[t]; ok {
+			return iname
+		}
+	case Deferred:
+		return deferredName[t]
+	}
+	return t
+}
+
+// deferredLoadOrder returns the Deferred variant's tables in topological
+// (parent-before-child) order, derived from each table's Interleave
+// parent -- which doubles as its FK topology. Unlike Normal/Interleaved,
+// where generateData loads every table concurrently, the Deferred
+// variant's FKs are validated against whatever parent rows exist at the
+// time its (single-statement, autocommit) insert runs, so a child table
+// loaded concurrently with its still-in-flight parent would routinely
+// fail with a foreign-key violation; loading strictly in topological
+// order guarantees every parent row a child references is already
+// committed.
+func deferredLoadOrder() []tableName {
+	order := make([]tableName, 0, len(workloadSpec.Tables))
+	visited := make(map[string]bool)
+
+	var visit func(t workload.Table)
+	visit = func(t workload.Table) {
+		if visited[t.Name] {
+			return
+		}
+		visited[t.Name] = true
+		if t.Interleave != nil {
+			for _, p := range workloadSpec.Tables {
+				if p.Name == t.Interleave.Parent {
+					visit(p)
+				}
+			}
+		}
+		order = append(order, deferredName[tableName(t.Name)])
+	}
+
+	for _, t := range workloadSpec.Tables {
+		visit(t)
+	}
+	return order
 }
 
-func loadSchema(db *sql.DB) error {
+func loadSchema(conn *dbConn) error {
 	stderr.Println("Creating tables with default schema")
 	for _, stmtName := range variantTables[schemaVar] {
-		if _, err := db.Exec(ddlStmts[stmtName]); err != nil {
+		if err := conn.Exec(ddlStmts[stmtName]); err != nil {
 			return errors.Wrap(err, "loading schema failed")
 		}
 	}
 
 	return nil
 }
+
+// applyConstraints adds FK constraints after data has been loaded, for
+// the Normal variant. The Deferred variant declares its FKs inline in
+// loadSchema's CREATE TABLE statements instead, so this is a no-op
+// there; the Interleaved variant never enforces FKs, matching the
+// original hardcoded schema's commented-out constraints.
+func applyConstraints(conn *dbConn) error {
+	if schemaVar != Normal {
+		return nil
+	}
+
+	stderr.Println("Adding foreign key constraints")
+	for _, t := range workloadSpec.Tables {
+		if t.Interleave == nil {
+			continue
+		}
+		stmt := fmt.Sprintf("alter table %s add constraint %s_fkey %s;",
+			t.Name, t.Name, buildForeignKeyClause(t, false))
+		if err := conn.Exec(stmt); err != nil {
+			return errors.Wrap(err, "adding foreign key constraints failed")
+		}
+	}
+
+	return nil
+}
+
+// preparedInsertName is the pgx prepared-statement name used for a
+// table's parameterized INSERT, primed on every pooled connection by
+// newPgxPool.
+func preparedInsertName(t tableName) string {
+	return string(t) + "_insert"
+}
+
+// paramPlaceholders returns a "($1,$2,...,$n)" placeholder tuple for a
+// parameterized INSERT, used by the pgx fast path in place of
+// string-formatting values directly into the statement text.
+func paramPlaceholders(n int) string {
+	s := make([]byte, 0, n*4)
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			s = append(s, ',')
+		}
+		s = append(s, []byte(fmt.Sprintf("$%d", i))...)
+	}
+	return "(" + string(s) + ")"
+}
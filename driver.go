@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+
+	"database/sql"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// dbConn wraps whichever backend --driver selected so the rest of the
+// program (schema setup, data loading, the worker loop) doesn't need to
+// know whether it's talking to database/sql (pq) or pgxpool (pgx)
+// directly.
+type dbConn struct {
+	driver string
+	sqlDB  *sql.DB
+	pgxDB  *pgxpool.Pool
+}
+
+// Exec runs a plain, non-prepared statement against whichever backend is
+// active. It's used for schema setup and admin statements (DROP/CREATE
+// DATABASE, DDL); under --driver=pgx the hot insert path uses the
+// per-table prepared statements primed by newPgxPool instead.
+func (c *dbConn) Exec(query string, args ...interface{}) error {
+	if c.driver == "pgx" {
+		_, err := c.pgxDB.Exec(context.Background(), query, args...)
+		return err
+	}
+	_, err := c.sqlDB.Exec(query, args...)
+	return err
+}
+
+func (c *dbConn) Close() {
+	if c.driver == "pgx" {
+		c.pgxDB.Close()
+		return
+	}
+	c.sqlDB.Close()
+}
+
+// newPgxPool builds a pgxpool.Pool and, via AfterConnect, prepares each
+// table's parameterized INSERT on every connection the pool establishes.
+// Because the statement is prepared once per connection rather than once
+// per statement execution, workers reuse it for the lifetime of the run
+// instead of paying database/sql's query-text formatting and re-parsing
+// cost on every call.
+func newPgxPool(ctx context.Context, connString string) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		for _, table := range tables {
+			stmt := insertStmts[table] + paramPlaceholders(len(tableTypes[table]))
+			if _, err := conn.Prepare(ctx, preparedInsertName(table), stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return pgxpool.NewWithConfig(ctx, cfg)
+}
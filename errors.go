@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+var maxRetries = flag.Int("max-retries", 5, "Maximum number of times to retry a statement that fails with a retryable error (serialization failure, deadlock, connection loss) before giving up. Uses truncated exponential backoff between attempts.")
+
+const (
+	initialBackoff = 1 * time.Millisecond
+	maxBackoff     = 1 * time.Second
+)
+
+// SQLSTATEs CockroachDB expects clients to retry, analogous to the
+// classes telemetry.RecordError buckets errors into: 40001 is a
+// serialization failure under SERIALIZABLE isolation, 40P01 is a
+// deadlock, and the 08xxx class is connection loss (often a node
+// restarting). Everything else -- integrity violations (23xxx), syntax
+// errors, etc. -- is treated as fatal.
+const (
+	sqlstateSerializationFailure = "40001"
+	sqlstateDeadlock             = "40P01"
+)
+
+// numErrors and numRetried are cumulative counters read once a tick by
+// main to print the error-summary line alongside the latency table.
+var (
+	numErrors  uint64
+	numRetried uint64
+)
+
+// errCounts holds a *uint64 per SQLSTATE seen, so the tick/summary output
+// can report which error classes dominate a run without taking a lock on
+// every insert.
+var errCounts sync.Map
+
+// sqlstate extracts the five-character SQLSTATE code from a pq or pgx
+// error, returning "" if err doesn't carry one (e.g. a context or network
+// error below the wire protocol).
+func sqlstate(err error) string {
+	if pqErr, ok := err.(*pq.Error); ok {
+		return string(pqErr.Code)
+	}
+	if pgErr, ok := err.(*pgconn.PgError); ok {
+		return pgErr.Code
+	}
+	return ""
+}
+
+func retryable(code string) bool {
+	switch code {
+	case sqlstateSerializationFailure, sqlstateDeadlock:
+		return true
+	}
+	return strings.HasPrefix(code, "08")
+}
+
+func recordError(code string) {
+	atomic.AddUint64(&numErrors, 1)
+	if code == "" {
+		code = "unknown"
+	}
+	v, _ := errCounts.LoadOrStore(code, new(uint64))
+	atomic.AddUint64(v.(*uint64), 1)
+}
+
+// execWithRetry runs fn -- a single statement attempt -- classifying any
+// error it returns and, for retryable SQLSTATEs, retrying with truncated
+// exponential backoff up to --max-retries times. Non-retryable errors are
+// returned immediately so callers can still treat them as fatal.
+func execWithRetry(fn func() error) error {
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		code := sqlstate(err)
+		recordError(code)
+		if !retryable(code) || attempt >= *maxRetries {
+			return err
+		}
+
+		atomic.AddUint64(&numRetried, 1)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// allErrorCounts returns every SQLSTATE seen so far and its count, for
+// the roachbench_errors_total{code=...} metric, which (unlike
+// errorSummaryLine) reports the full set rather than just the top few.
+func allErrorCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	errCounts.Range(func(k, v interface{}) bool {
+		counts[k.(string)] = atomic.LoadUint64(v.(*uint64))
+		return true
+	})
+	return counts
+}
+
+type errCount struct {
+	code  string
+	count uint64
+}
+
+// topErrorCodes returns up to n SQLSTATEs seen so far, ordered by count
+// descending, formatted as "code=count" for the tick/summary output.
+func topErrorCodes(n int) []string {
+	var counts []errCount
+	errCounts.Range(func(k, v interface{}) bool {
+		counts = append(counts, errCount{code: k.(string), count: atomic.LoadUint64(v.(*uint64))})
+		return true
+	})
+	sort.Slice(counts, func(i, j int) bool { return counts[i].count > counts[j].count })
+
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	codes := make([]string, len(counts))
+	for i, c := range counts {
+		codes[i] = fmt.Sprintf("%s=%d", c.code, c.count)
+	}
+	return codes
+}
+
+// errorSummaryLine formats the second line printed alongside the
+// per-tick and final latency tables: total errors, retried ops, and the
+// top-3 SQLSTATEs by count. All three are cumulative since the run
+// started; the per-tick line does not break them down per interval.
+func errorSummaryLine() string {
+	total := atomic.LoadUint64(&numErrors)
+	retried := atomic.LoadUint64(&numRetried)
+	if total == 0 {
+		return fmt.Sprintf("  errors %d, retried %d", total, retried)
+	}
+	return fmt.Sprintf("  errors %d, retried %d, top codes: %s", total, retried, strings.Join(topErrorCodes(3), ", "))
+}
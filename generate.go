@@ -1,7 +1,7 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -9,6 +9,9 @@ import (
 	"os/exec"
 	"strconv"
 	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
 )
 
 const (
@@ -19,16 +22,28 @@ const (
 	decMax    = 42
 )
 
-func generateData(db *sql.DB) error {
+func generateData(conn *dbConn) error {
 	// Insert pseudo-random data into tables.
 	stderr.Println("Inserting into tables, this may take a while...")
-	var wg sync.WaitGroup
-	for _, table := range tables {
-		writer := newWriter(db, table, &wg)
-		go writer.insertData(&wg)
-	}
 
-	wg.Wait()
+	if schemaVar == Deferred {
+		// Each table's insert is its own autocommit statement, so a
+		// child table loaded concurrently with its parent can reference
+		// rows the parent hasn't committed yet. Load one table at a
+		// time in topological order instead.
+		for _, table := range deferredLoadOrder() {
+			var wg sync.WaitGroup
+			writer := newWriter(conn, table, &wg)
+			writer.insertData(&wg)
+		}
+	} else {
+		var wg sync.WaitGroup
+		for _, table := range tables {
+			writer := newWriter(conn, table, &wg)
+			go writer.insertData(&wg)
+		}
+		wg.Wait()
+	}
 
 	// Dump all data to the dumpfile.
 	stderr.Printf("Data insertion done. Dumping to file %s...\n", *dumpfile)
@@ -37,6 +52,8 @@ func generateData(db *sql.DB) error {
 		cmd = exec.Command("cockroach", "dump", "--insecure", dbName)
 	} else if *dbms == "postgres" {
 		cmd = exec.Command("pg_dump", dbName)
+	} else if mysqlDBMS() {
+		cmd = exec.Command("mysqldump", dbName)
 	}
 
 	output, err := cmd.Output()
@@ -54,11 +71,12 @@ func generateData(db *sql.DB) error {
 
 type tableWriter struct {
 	pkey  int
-	db    *sql.DB
+	conn  *dbConn
 	table tableName
 	rnd   *rand.Rand
 
 	columnTypes []columnType
+	textLens    []int
 
 	// Updated per insertion.
 	// Scratch space for each individual value.
@@ -67,10 +85,10 @@ type tableWriter struct {
 	valuesBuf []byte
 }
 
-func newWriter(db *sql.DB, table tableName, wg *sync.WaitGroup) tableWriter {
+func newWriter(conn *dbConn, table tableName, wg *sync.WaitGroup) tableWriter {
 	wg.Add(1)
 	w := tableWriter{
-		db:    db,
+		conn:  conn,
 		table: table,
 	}
 
@@ -81,32 +99,188 @@ func newWriter(db *sql.DB, table tableName, wg *sync.WaitGroup) tableWriter {
 	w.pkey = 1
 	w.scratch = make([]byte, 0, textLen+2)
 	w.columnTypes = tableTypes[w.table]
+	w.textLens = tableTextLengths[w.table]
 	return w
 }
 
 // Meant to be concurrently executed.
 func (tw *tableWriter) insertData(wg *sync.WaitGroup) {
 	remainingRows := tableRows[tw.table]
-	curBatch := batchSize
-	for remainingRows > 0 {
-		if remainingRows < curBatch {
-			curBatch = remainingRows
-		}
 
-		// Generate a batch of values to insert.
-		tw.valuesBuf = tw.genValues(curBatch)
-		// Form insert statement and execute.
-		if _, err := tw.db.Exec(fmt.Sprintf("%s %s;", insertStmts[tw.table], string(tw.valuesBuf))); err != nil {
+	if *loaderFlag == "copy" {
+		var err error
+		if tw.conn.driver == "pgx" {
+			err = tw.copyInsertPGX(remainingRows)
+		} else {
+			err = tw.copyInsertPQ(remainingRows)
+		}
+		if err != nil {
+			recordError(sqlstate(err))
 			log.Fatal(err)
 		}
+	} else {
+		curBatch := batchSize
+		for remainingRows > 0 {
+			if remainingRows < curBatch {
+				curBatch = remainingRows
+			}
 
-		remainingRows -= curBatch
+			if tw.conn.driver == "pgx" {
+				// Stream typed argument rows straight into the table's
+				// prepared statement, bypassing value-to-SQL-text formatting.
+				for _, args := range tw.genArgs(curBatch) {
+					args := args
+					if err := execWithRetry(func() error {
+						_, err := tw.conn.pgxDB.Exec(context.Background(), preparedInsertName(tw.table), args...)
+						return err
+					}); err != nil {
+						log.Fatal(err)
+					}
+				}
+			} else {
+				// Generate a batch of values to insert.
+				tw.valuesBuf = tw.genValues(curBatch)
+				// Form insert statement and execute.
+				stmt := fmt.Sprintf("%s %s;", insertStmts[tw.table], string(tw.valuesBuf))
+				if err := execWithRetry(func() error {
+					_, err := tw.conn.sqlDB.Exec(stmt)
+					return err
+				}); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			remainingRows -= curBatch
+		}
 	}
 
 	stderr.Printf("Inserting into table <%s> complete.\n", tw.table)
 	wg.Add(-1)
 }
 
+// genRow generates a single row of typed values, dispatching on column
+// type the same way genValues does for the text-formatted path. It's the
+// unit both genArgs (prepared-statement batches) and the COPY loader's
+// streaming CopyFromSource build on, so randText/randInt/randDec/
+// randFkeyInt's typed values never get materialized as []byte/string SQL
+// fragments on the copy path.
+func (tw *tableWriter) genRow() []interface{} {
+	row := genRow(tw.table, tw.rnd, &tw.pkey)
+	return row
+}
+
+// genRow generates a single row of typed values for table, dispatching on
+// column type the same way genValues does for the text-formatted path.
+// It's the unit both tableWriter.genRow (batch loading) and the worker
+// loop build on, so randText/randInt/randDec/randFkeyInt's typed values
+// never get materialized as []byte/string SQL fragments except on the
+// --driver=pq/--loader=insert text-formatting path.
+func genRow(table tableName, rnd *rand.Rand, pkey *int) []interface{} {
+	types := tableTypes[table]
+	textLens := tableTextLengths[table]
+	row := make([]interface{}, len(types))
+	for j, c := range types {
+		switch c {
+		case PkeyInt:
+			row[j] = *pkey
+			*pkey++
+		case FkeyInt:
+			row[j] = randFkeyIntVal(table, j, rnd)
+		case Int:
+			row[j] = rnd.Intn(intMax)
+		case Text:
+			row[j] = randString(rnd, textLens[j])
+		case Dec:
+			row[j] = rnd.Float64() * decMax
+		default:
+			panic("undefined column type")
+		}
+	}
+	return row
+}
+
+// genArgs generates nTuples rows of typed values for the pgx
+// prepared-statement fast path.
+func (tw *tableWriter) genArgs(nTuples int) [][]interface{} {
+	rows := make([][]interface{}, nTuples)
+	for i := 0; i < nTuples; i++ {
+		rows[i] = tw.genRow()
+	}
+	return rows
+}
+
+// copyInsertPQ bulk-loads nRows rows via pq.CopyIn, the database/sql
+// binding for Postgres' COPY FROM STDIN protocol. It's a single
+// transaction per table, matching pq's requirement that a COPY statement
+// be prepared and executed against the same *sql.Tx.
+func (tw *tableWriter) copyInsertPQ(nRows int) error {
+	txn, err := tw.conn.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := txn.Prepare(pq.CopyIn(tableSQLName[tw.table], tableColumns[tw.table]...))
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < nRows; i++ {
+		if _, err := stmt.Exec(tw.genRow()...); err != nil {
+			return err
+		}
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// copyInsertPGX bulk-loads nRows rows via pgxpool's CopyFrom, streaming
+// generated rows straight from the RNG through a tableWriterCopySource
+// rather than buffering them.
+func (tw *tableWriter) copyInsertPGX(nRows int) error {
+	ctx := context.Background()
+	conn, err := tw.conn.pgxDB.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.CopyFrom(ctx, pgx.Identifier{tableSQLName[tw.table]}, tableColumns[tw.table],
+		&tableWriterCopySource{tw: tw, remaining: nRows})
+	return err
+}
+
+// tableWriterCopySource implements pgx.CopyFromSource, generating each
+// row lazily from tw's RNG as pgx asks for it instead of materializing
+// the whole batch up front.
+type tableWriterCopySource struct {
+	tw        *tableWriter
+	remaining int
+	cur       []interface{}
+}
+
+func (s *tableWriterCopySource) Next() bool {
+	if s.remaining <= 0 {
+		return false
+	}
+	s.remaining--
+	s.cur = s.tw.genRow()
+	return true
+}
+
+func (s *tableWriterCopySource) Values() ([]interface{}, error) {
+	return s.cur, nil
+}
+
+func (s *tableWriterCopySource) Err() error {
+	return nil
+}
+
 func (tw *tableWriter) genValues(nTuples int) []byte {
 	values := tw.valuesBuf[:0]
 	commaTuples := false
@@ -131,7 +305,7 @@ func (tw *tableWriter) genValues(nTuples int) []byte {
 			case Int:
 				temp = tw.randInt()
 			case Text:
-				temp = tw.randText()
+				temp = tw.randText(i)
 			case Dec:
 				temp = tw.randDec()
 			default:
@@ -147,36 +321,41 @@ func (tw *tableWriter) genValues(nTuples int) []byte {
 }
 
 func (tw *tableWriter) randFkeyInt(cidx int) []byte {
-	var temp int
-	switch tw.table {
-	case Product, ProductInterleaved, Store, StoreInterleaved:
-		// Foreign key (merchant id) must be between 1 and nMerchants.
-		temp = tw.rnd.Intn(*nMerchants) + 1
-	case Variant, VariantInterleaved:
-		if cidx == 0 {
-			// Foreign key (merchant id) must be between 1 and nMerchants.
-			temp = tw.rnd.Intn(*nMerchants) + 1
-		} else if cidx == 1 {
-			// Foreign key (product id) must be between 1 and nProducts.
-			temp = tw.rnd.Intn(*nProducts) + 1
-		} else {
-			panic("invalid fkey column index")
-		}
-	default:
-		panic("unsupported table for fkey generation")
-	}
+	return []byte(strconv.Itoa(tw.randFkeyIntVal(cidx)))
+}
+
+func (tw *tableWriter) randFkeyIntVal(cidx int) int {
+	return randFkeyIntVal(tw.table, cidx, tw.rnd)
+}
 
-	return []byte(strconv.Itoa(temp))
+// randFkeyIntVal generates a value for the cidx'th column of table, which
+// tableFkeyRefs (built from the workload spec's `references` fields)
+// says is a foreign key into another table's primary key range.
+func randFkeyIntVal(table tableName, cidx int, rnd *rand.Rand) int {
+	refTable, ok := tableFkeyRefs[table][cidx]
+	if !ok {
+		panic(fmt.Sprintf("no fkey reference declared for %s column %d", table, cidx))
+	}
+	n, ok := tableRows[refTable]
+	if !ok {
+		panic(fmt.Sprintf("%s references unknown table %q", table, refTable))
+	}
+	return rnd.Intn(n) + 1
 }
 
 func (tw *tableWriter) randInt() []byte {
 	return []byte(strconv.Itoa(tw.rnd.Intn(intMax)))
 }
 
-func (tw *tableWriter) randText() []byte {
+// randText generates the cidx'th column's text literal, using that
+// column's workload-spec TextLength (tableTextLengths) rather than a
+// fixed length, so a spec declaring e.g. text_length: 20 gets 20-char
+// strings instead of always the textLen default.
+func (tw *tableWriter) randText(cidx int) []byte {
+	n := tw.textLens[cidx]
 	scratch := tw.scratch[:0]
 	scratch = append(scratch, '\'')
-	for i := 0; i < textLen; i++ {
+	for i := 0; i < n; i++ {
 		scratch = append(scratch, textChars[tw.rnd.Intn(len(textChars))])
 	}
 	scratch = append(scratch, '\'')
@@ -186,3 +365,15 @@ func (tw *tableWriter) randText() []byte {
 func (tw *tableWriter) randDec() []byte {
 	return []byte(fmt.Sprintf("%.2f", tw.rnd.Float64()*decMax))
 }
+
+// randString generates a random string of length n from textChars, used
+// by genRow and so shared by the typed-argument path (genArgs), the COPY
+// loaders, and the worker loop. Unlike randText it allocates a fresh
+// string per call instead of reusing a tableWriter's scratch buffer.
+func randString(rnd *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = textChars[rnd.Intn(len(textChars))]
+	}
+	return string(b)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -17,7 +18,10 @@ import (
 	"time"
 
 	"github.com/codahale/hdrhistogram"
+	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+
+	"github.com/richardwu/roach-bench/workload"
 )
 
 var dbName = "roachbench"
@@ -34,21 +38,53 @@ var load = flag.Bool("load", false, "Generate fresh data from --seed (determinis
 var dumpfile = flag.String("dump-file", "", "If specified, will dump DB contents to this file.")
 var loadfile = flag.String("load-file", "", "Filepath of file generated from --dump to load into database.")
 var seed = flag.Int64("seed", 42, "Pseudo-random seed used to generate data.")
-var dbms = flag.String("dbms", "cockroach", "DBMS used (values: 'cockroach' or 'postgres'). Relevant for --load and --dump.")
+var dbms = flag.String("dbms", "cockroach", "DBMS used (values: 'cockroach', 'postgres', 'mysql', or 'tidb'). Relevant for --load and --dump.")
 var databases = map[string]bool{
 	"cockroach": true,
 	"postgres":  true,
+	"mysql":     true,
+	"tidb":      true,
+}
+
+// mysqlDBMS reports whether *dbms addresses a MySQL-wire-protocol
+// cluster (plain MySQL or TiDB), which share DDL dialect, DSN shape, and
+// dump/load tooling and so are handled identically everywhere below.
+func mysqlDBMS() bool {
+	return *dbms == "mysql" || *dbms == "tidb"
 }
-var variant = flag.String("variant", "normal", "How tables are created (values: 'normal' or 'interleaved'). Use with --load/--load-file.")
+
+var variant = flag.String("variant", "normal", "How tables are created (values: 'normal', 'interleaved', or 'deferred'). Use with --load/--load-file.")
 var variants = map[string]bool{
 	"normal":      true,
 	"interleaved": true,
+	"deferred":    true,
 }
 
-var nMerchants = flag.Int("merchants", 10000, "Number of rows in table <merchant> to generate. Use with --load.")
-var nProducts = flag.Int("products", 1000000, "number of rows in table <product> to generate. Use with --load.")
-var nVariants = flag.Int("variants", 10000000, "number of rows in table <variant> to generate. Use with --load.")
-var nStores = flag.Int("stores", 50000, "Number of rows in table <store> to generate. Use with --load.")
+// driverFlag selects the Go driver used both for the worker loop and for
+// data loading. "pq" goes through database/sql as before; "pgx" talks to
+// pgxpool directly, skipping database/sql's query-text path entirely and
+// reusing a prepared statement per table across all workers.
+var driverFlag = flag.String("driver", "pq", "SQL driver to use (values: 'pq' or 'pgx').")
+var drivers = map[string]bool{
+	"pq":  true,
+	"pgx": true,
+}
+
+// loaderFlag selects how generateData bulk-loads rows. "insert" batches
+// rows into multi-row INSERT statements as before; "copy" streams rows
+// via the PostgreSQL COPY protocol (pq.CopyIn / pgx CopyFrom), which
+// skips per-statement SQL text entirely.
+var loaderFlag = flag.String("loader", "insert", "Data-loading strategy for --load (values: 'insert' or 'copy').")
+var loaders = map[string]bool{
+	"insert": true,
+	"copy":   true,
+}
+
+// workloadFile points at the YAML spec describing the schema, row
+// counts, and query mix to benchmark, replacing the old hardcoded
+// merchant/product/variant/store schema and --merchants/--products/
+// --variants/--stores row-count flags.
+var workloadFile = flag.String("workload", "workloads/ecommerce.yaml", "Path to a workload spec file describing the schema and query mix to benchmark.")
 
 var schemaVar schemaVariant
 var tables []tableName
@@ -56,18 +92,36 @@ var tables []tableName
 func init() {
 	flag.Parse()
 
+	spec, err := workload.Load(*workloadFile)
+	if err != nil {
+		log.Fatalf("loading --workload=%s: %v", *workloadFile, err)
+	}
+	workloadQueries = spec.Queries
+
 	// Schema DDL statements initialization.
-	initDDL()
+	initDDL(spec)
 
 	if !databases[*dbms] {
-		log.Fatalf("--database must either be 'cockroach' or 'postgres'")
+		log.Fatalf("--dbms must be one of 'cockroach', 'postgres', 'mysql', or 'tidb'")
 	}
 	if !variants[*variant] {
-		log.Fatalf("--variant must either be 'normal' or 'interleaved'")
+		log.Fatalf("--variant must be one of 'normal', 'interleaved', or 'deferred'")
+	}
+	if !drivers[*driverFlag] {
+		log.Fatalf("--driver must either be 'pq' or 'pgx'")
+	}
+	if !loaders[*loaderFlag] {
+		log.Fatalf("--loader must either be 'insert' or 'copy'")
 	}
 
-	if *dbms == "postgres" && *variant != "normal" {
-		log.Fatalf("--database=postgres only works with --variant=normal")
+	if mysqlDBMS() && *variant != "normal" {
+		log.Fatalf("--dbms=%s only works with --variant=normal", *dbms)
+	}
+	if *variant == "interleaved" && *dbms != "cockroach" {
+		log.Fatalf("--variant=interleaved requires --dbms=cockroach")
+	}
+	if *loaderFlag == "copy" && mysqlDBMS() {
+		log.Fatalf("--loader=copy requires a Postgres-wire-protocol --dbms (cockroach or postgres)")
 	}
 
 	schemaVar = schemaVariant(*variant)
@@ -79,6 +133,10 @@ func init() {
 		// Need to connect to template DB to perform drops and loads.
 		if *dbms == "postgres" && (*drop || *load || *loadfile != "") {
 			*dbURL = defaultURL("template1")
+		} else if mysqlDBMS() && (*drop || *load || *loadfile != "") {
+			// MySQL/TiDB has no template database; connect without
+			// selecting one to issue the CREATE DATABASE itself.
+			*dbURL = defaultURL("")
 		} else {
 			*dbURL = defaultURL(dbName)
 		}
@@ -92,33 +150,74 @@ func defaultURL(name string) string {
 	if *dbms == "postgres" {
 		return fmt.Sprintf("postgres://richardwu@localhost:5432/%s?sslmode=disable", name)
 	}
+	if mysqlDBMS() {
+		// TiDB clusters are addressed the same way as MySQL, just on
+		// TiDB's default SQL port (4000) rather than MySQL's (3306).
+		port := 3306
+		if *dbms == "tidb" {
+			port = 4000
+		}
+		return fmt.Sprintf("root:@tcp(localhost:%d)/%s", port, name)
+	}
 	return fmt.Sprintf("postgres://root@localhost:26257/%s?sslmode=disable", name)
 }
 
-func dropDB(db *sql.DB) {
+func dropDB(conn *dbConn) {
 	stderr.Printf("Dropping database %s\n", dbName)
-	if _, err := db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s CASCADE", dbName)); err != nil {
+	stmt := fmt.Sprintf("DROP DATABASE IF EXISTS %s CASCADE", dbName)
+	if mysqlDBMS() {
+		// MySQL/TiDB's DROP DATABASE has no CASCADE keyword; dropping the
+		// database already drops everything in it.
+		stmt = fmt.Sprintf("DROP DATABASE IF EXISTS %s", dbName)
+	}
+	if err := conn.Exec(stmt); err != nil {
 		log.Fatalf("could not drop database: %v", err)
 	}
 	stderr.Println("Dropping database complete.")
 }
 
-func createDB(db *sql.DB) {
+func createDB(conn *dbConn) {
 	stderr.Printf("Creating database %s\n", dbName)
-	if _, err := db.Exec(fmt.Sprintf("CREATE DATABASE %s", dbName)); err != nil {
+	if err := conn.Exec(fmt.Sprintf("CREATE DATABASE %s", dbName)); err != nil {
 		log.Fatalf("could not create database: %v", err)
 	}
 	stderr.Println("Creating database complete.")
 }
 
-func connectDB() *sql.DB {
+// connectDB opens a connection (or pool) to --url using whichever driver
+// --driver selects. The pq path goes through database/sql as before; the
+// pgx path builds a pgxpool directly and primes every pooled connection
+// with this run's prepared INSERT statements via newPgxPool.
+func connectDB() *dbConn {
 	stderr.Printf("connecting to db: %s\n", *dbURL)
 
+	if mysqlDBMS() {
+		// MySQL DSNs ("user:pass@tcp(host:port)/db") aren't URLs, and
+		// pgx/pgxpool only speak the Postgres wire protocol, so MySQL/TiDB
+		// always goes through database/sql regardless of --driver.
+		db, err := sql.Open("mysql", *dbURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		db.SetMaxOpenConns(len(variantTables[schemaVar]) + 1)
+		db.SetMaxIdleConns(len(variantTables[schemaVar]) + 1)
+		return &dbConn{driver: "pq", sqlDB: db}
+	}
+
 	// Open connection to DB.
 	parsedURL, err := url.Parse(*dbURL)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if *driverFlag == "pgx" {
+		pool, err := newPgxPool(context.Background(), parsedURL.String())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return &dbConn{driver: "pgx", pgxDB: pool}
+	}
+
 	db, err := sql.Open("postgres", parsedURL.String())
 	if err != nil {
 		log.Fatal(err)
@@ -127,29 +226,26 @@ func connectDB() *sql.DB {
 	db.SetMaxOpenConns(len(variantTables[schemaVar]) + 1)
 	db.SetMaxIdleConns(len(variantTables[schemaVar]) + 1)
 
-	return db
+	return &dbConn{driver: "pq", sqlDB: db}
 }
 
 func main() {
-	if Product == ProductInterleaved {
-		panic("oh no")
-	}
-
-	db := connectDB()
-	defer db.Close()
+	conn := connectDB()
+	defer conn.Close()
 
 	if *drop {
-		dropDB(db)
+		dropDB(conn)
 	}
 
 	if *drop || *loadfile != "" || *load {
-		createDB(db)
+		createDB(conn)
 	}
 
-	// Connect to the newly created DB for postgres.
-	if *dbms == "postgres" && (*drop || *load || *loadfile != "") {
+	// Connect to the newly created DB for postgres/mysql/tidb, which (unlike
+	// cockroach) need to reconnect selecting the database just created.
+	if (*dbms == "postgres" || mysqlDBMS()) && (*drop || *load || *loadfile != "") {
 		*dbURL = defaultURL(dbName)
-		db = connectDB()
+		conn = connectDB()
 	}
 
 	if *loadfile != "" {
@@ -160,6 +256,8 @@ func main() {
 			cmd = exec.Command("cockroach", "sql", "--insecure", "--database="+dbName)
 		} else if *dbms == "postgres" {
 			cmd = exec.Command("psql", dbName)
+		} else if mysqlDBMS() {
+			cmd = exec.Command("mysql", dbName)
 		}
 
 		// Pipe the file content of the dump file.
@@ -190,13 +288,13 @@ func main() {
 		stderr.Printf("Loading from file %s complete!\n", *loadfile)
 	} else if *load {
 		// Create tables.
-		if err := loadSchema(db); err != nil {
+		if err := loadSchema(conn); err != nil {
 			log.Fatal(err)
 		}
-		if err := generateData(db); err != nil {
+		if err := generateData(conn); err != nil {
 			log.Fatal(err)
 		}
-		if err := applyConstraints(db); err != nil {
+		if err := applyConstraints(conn); err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -209,6 +307,8 @@ func main() {
 			cmd = exec.Command("cockroach", "dump", "--insecure", dbName)
 		} else if *dbms == "postgres" {
 			cmd = exec.Command("pg_dump", dbName)
+		} else if mysqlDBMS() {
+			cmd = exec.Command("mysqldump", dbName)
 		}
 
 		output, err := cmd.Output()
@@ -228,10 +328,14 @@ func main() {
 	var wg sync.WaitGroup
 	workers := make([]*worker, *concurrency)
 	for i := range workers {
-		workers[i] = newWorker(db, &wg)
+		workers[i] = newWorker(conn, &wg, i)
 		go workers[i].run(&wg)
 	}
 
+	if *metricsAddr != "" {
+		go startMetricsServer(*metricsAddr)
+	}
+
 	tick := time.Tick(time.Second)
 	done := make(chan os.Signal, 3)
 	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
@@ -293,6 +397,18 @@ func main() {
 				time.Duration(h.ValueAtQuantile(95)).Seconds()*1000,
 				time.Duration(h.ValueAtQuantile(99)).Seconds()*1000,
 				time.Duration(h.ValueAtQuantile(100)).Seconds()*1000)
+			fmt.Println(errorSummaryLine())
+
+			recordMetricsSnapshot(metricsSnapshot{
+				Ops:        ops,
+				Errors:     atomic.LoadUint64(&numErrors),
+				Retried:    atomic.LoadUint64(&numRetried),
+				ErrorCodes: allErrorCounts(),
+				P50:        time.Duration(h.ValueAtQuantile(50)).Seconds() * 1000,
+				P95:        time.Duration(h.ValueAtQuantile(95)).Seconds() * 1000,
+				P99:        time.Duration(h.ValueAtQuantile(99)).Seconds() * 1000,
+				PMax:       time.Duration(h.ValueAtQuantile(100)).Seconds() * 1000,
+			})
 
 			lastOps = ops
 			lastNow = now
@@ -318,6 +434,18 @@ func main() {
 				time.Duration(cumLatency.ValueAtQuantile(95)).Seconds()*1000,
 				time.Duration(cumLatency.ValueAtQuantile(99)).Seconds()*1000,
 				time.Duration(cumLatency.ValueAtQuantile(100)).Seconds()*1000)
+			fmt.Println(errorSummaryLine())
+
+			recordMetricsSnapshot(metricsSnapshot{
+				Ops:        ops,
+				Errors:     atomic.LoadUint64(&numErrors),
+				Retried:    atomic.LoadUint64(&numRetried),
+				ErrorCodes: allErrorCounts(),
+				P50:        time.Duration(cumLatency.ValueAtQuantile(50)).Seconds() * 1000,
+				P95:        time.Duration(cumLatency.ValueAtQuantile(95)).Seconds() * 1000,
+				P99:        time.Duration(cumLatency.ValueAtQuantile(99)).Seconds() * 1000,
+				PMax:       time.Duration(cumLatency.ValueAtQuantile(100)).Seconds() * 1000,
+			})
 			return
 		}
 	}
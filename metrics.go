@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricsAddr, if set, serves Prometheus/OpenMetrics text at /metrics;
+// metricsFile, if set, appends one JSON line per tick with the same
+// fields. Together these let a run be scheduled across a matrix of
+// variants/DBMSes with results collected centrally instead of read off
+// the interactive tick table.
+var metricsAddr = flag.String("metrics-addr", "", "If set, serve Prometheus/OpenMetrics metrics at http://<addr>/metrics (e.g. ':9090').")
+var metricsFile = flag.String("metrics-file", "", "If set, append one JSON line per tick to this file with the same fields exposed at --metrics-addr.")
+
+// metricsSnapshot is one tick's worth of aggregate state, built from the
+// same merged histogram and error counters the tick loop prints (the
+// tick's interval histogram per tick, the cumulative one for the final
+// summary), so /metrics and --metrics-file never disagree with the
+// human-readable p50/p95/p99 table.
+type metricsSnapshot struct {
+	Ops        uint64            `json:"ops"`
+	Errors     uint64            `json:"errors"`
+	Retried    uint64            `json:"retried"`
+	ErrorCodes map[string]uint64 `json:"error_codes,omitempty"`
+	P50        float64           `json:"p50_ms"`
+	P95        float64           `json:"p95_ms"`
+	P99        float64           `json:"p99_ms"`
+	PMax       float64           `json:"p_max_ms"`
+}
+
+var (
+	metricsMu   sync.Mutex
+	metricsLast metricsSnapshot
+)
+
+// recordMetricsSnapshot is called once per tick from main's tick loop,
+// right after merging worker latency into the cumulative histogram. It
+// updates the snapshot the /metrics handler serves and, if --metrics-file
+// is set, appends it as a JSON line.
+func recordMetricsSnapshot(snap metricsSnapshot) {
+	metricsMu.Lock()
+	metricsLast = snap
+	metricsMu.Unlock()
+
+	if *metricsFile != "" {
+		if err := appendMetricsFile(snap); err != nil {
+			stderr.Printf("writing --metrics-file: %v\n", err)
+		}
+	}
+}
+
+// appendMetricsFile appends snap as a single JSON line to --metrics-file,
+// creating it if necessary.
+func appendMetricsFile(snap metricsSnapshot) error {
+	f, err := os.OpenFile(*metricsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	_, err = f.Write(line)
+	return err
+}
+
+// startMetricsServer starts the /metrics HTTP endpoint in the background.
+// It never returns; callers run it in a goroutine.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	stderr.Printf("serving metrics at http://%s/metrics\n", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// metricsHandler renders the latest snapshot in OpenMetrics/Prometheus
+// text exposition format: a counter for total ops, a counter per
+// SQLSTATE seen, and a summary of latency quantiles matching the printed
+// p50/p95/p99/pMax columns.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	snap := metricsLast
+	metricsMu.Unlock()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# TYPE roachbench_ops_total counter\n")
+	fmt.Fprintf(&sb, "roachbench_ops_total %d\n", snap.Ops)
+
+	fmt.Fprintf(&sb, "# TYPE roachbench_errors_total counter\n")
+	codes := make([]string, 0, len(snap.ErrorCodes))
+	for code := range snap.ErrorCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(&sb, "roachbench_errors_total{code=%q} %d\n", code, snap.ErrorCodes[code])
+	}
+
+	fmt.Fprintf(&sb, "# TYPE roachbench_retried_total counter\n")
+	fmt.Fprintf(&sb, "roachbench_retried_total %d\n", snap.Retried)
+
+	fmt.Fprintf(&sb, "# TYPE roachbench_latency_ms summary\n")
+	fmt.Fprintf(&sb, "roachbench_latency_ms{quantile=\"0.5\"} %f\n", snap.P50)
+	fmt.Fprintf(&sb, "roachbench_latency_ms{quantile=\"0.95\"} %f\n", snap.P95)
+	fmt.Fprintf(&sb, "roachbench_latency_ms{quantile=\"0.99\"} %f\n", snap.P99)
+	fmt.Fprintf(&sb, "roachbench_latency_ms{quantile=\"1\"} %f\n", snap.PMax)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(sb.String()))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+
+	"github.com/richardwu/roach-bench/workload"
+)
+
+const (
+	minLatency = 100 * time.Microsecond
+	maxLatency = 10 * time.Second
+)
+
+// numOps is the cumulative count of completed worker operations, read
+// once a tick by main to compute throughput.
+var numOps uint64
+
+// workloadQueries are the weighted write queries loaded from --workload,
+// picked by insertOne to pick which table a given op writes to.
+var workloadQueries []workload.Query
+
+// pickQuery picks one of workloadQueries at random, weighted by its
+// Weight field.
+func pickQuery(rnd *rand.Rand) workload.Query {
+	var total float64
+	for _, q := range workloadQueries {
+		total += q.Weight
+	}
+
+	r := rnd.Float64() * total
+	for _, q := range workloadQueries {
+		r -= q.Weight
+		if r <= 0 {
+			return q
+		}
+	}
+	return workloadQueries[len(workloadQueries)-1]
+}
+
+// worker repeatedly issues one of the spec's weighted write queries,
+// recording per-op latency into a windowed histogram that main merges
+// into the cumulative histogram once a tick.
+type worker struct {
+	conn  *dbConn
+	index int
+	rnd   *rand.Rand
+	pkeys map[tableName]int
+
+	latency *hdrhistogram.WindowedHistogram
+}
+
+// newWorker builds the index'th of *concurrency workers. index seeds the
+// RNG (*seed + index) and picks the worker's starting pkey (index + 1,
+// strided by *concurrency in insertOne) so that concurrent workers,
+// which otherwise all call pickQuery/genRow in lockstep, never generate
+// the same row -- an identical seed and pkey range across workers made
+// every worker race its peers onto the same primary key.
+func newWorker(conn *dbConn, wg *sync.WaitGroup, index int) *worker {
+	wg.Add(1)
+	return &worker{
+		conn:    conn,
+		index:   index,
+		rnd:     rand.New(rand.NewSource(*seed + int64(index))),
+		pkeys:   make(map[tableName]int),
+		latency: hdrhistogram.NewWindowed(1, minLatency.Nanoseconds(), maxLatency.Nanoseconds(), 1),
+	}
+}
+
+func (w *worker) run(wg *sync.WaitGroup) {
+	defer wg.Add(-1)
+	for {
+		start := time.Now()
+		if err := execWithRetry(w.insertOne); err != nil {
+			log.Fatal(err)
+		}
+
+		w.latency.Lock()
+		if err := w.latency.Current.RecordValue(time.Since(start).Nanoseconds()); err != nil {
+			log.Fatal(err)
+		}
+		w.latency.Unlock()
+
+		atomic.AddUint64(&numOps, 1)
+	}
+}
+
+// insertOne picks a weighted query from the workload spec and inserts a
+// generated row into its table. Under --driver=pgx it executes the
+// prepared statement primed by newPgxPool with typed args ($1,$2,...);
+// under --driver=pq it falls back to string-formatting the values into
+// the statement text, as generateData does for the insert loader. Under
+// --variant=deferred, the insert runs inside its own transaction with
+// "SET CONSTRAINTS ALL DEFERRED" issued first, so the FK checks inline
+// in that variant's CREATE TABLE are deferred to commit instead of
+// being checked (and paid for) per statement.
+func (w *worker) insertOne() error {
+	// pickQuery names the bare spec table (e.g. "variant"); resolve it to
+	// the active --variant's schema name (e.g. "variant (interleaved)"),
+	// since that's what newPgxPool prepared statements and insertStmts
+	// are keyed on for anything but --variant=normal.
+	table := variantTableName(tableName(pickQuery(w.rnd).Table))
+
+	// Each worker owns a disjoint pkey range for table: w.index+1,
+	// w.index+1+concurrency, w.index+1+2*concurrency, ... genRow bumps
+	// pkey by one per call, so re-striding by *concurrency-1 after each
+	// call keeps every worker's range disjoint from every other's.
+	pkey := w.pkeys[table]
+	if pkey == 0 {
+		pkey = w.index + 1
+	}
+	args := genRow(table, w.rnd, &pkey)
+	w.pkeys[table] = pkey + (*concurrency - 1)
+
+	if w.conn.driver == "pgx" {
+		ctx := context.Background()
+		if schemaVar != Deferred {
+			_, err := w.conn.pgxDB.Exec(ctx, preparedInsertName(table), args...)
+			return err
+		}
+
+		tx, err := w.conn.pgxDB.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "set constraints all deferred"); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		if _, err := tx.Exec(ctx, preparedInsertName(table), args...); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = formatSQLValue(a)
+	}
+	stmt := fmt.Sprintf("%s (%s);", insertStmts[table], strings.Join(parts, ","))
+
+	if schemaVar != Deferred {
+		_, err := w.conn.sqlDB.Exec(stmt)
+		return err
+	}
+
+	tx, err := w.conn.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("set constraints all deferred"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(stmt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// formatSQLValue renders a typed value generated by genRow as a SQL
+// literal, for the --driver=pq path which (unlike pgx's prepared
+// statements) formats values directly into the statement text.
+func formatSQLValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return "'" + val + "'"
+	case int:
+		return strconv.Itoa(val)
+	case float64:
+		return fmt.Sprintf("%.2f", val)
+	default:
+		panic(fmt.Sprintf("unsupported generated value type %T", v))
+	}
+}
@@ -0,0 +1,130 @@
+// Package workload describes a benchmark schema and query mix as data,
+// loaded from a YAML spec file, rather than as Go source. roach-bench's
+// old hardcoded merchant/product/variant/store schema lives on as
+// workloads/ecommerce.yaml; dropping in a new spec (e.g. something
+// TPC-C-like) no longer requires touching Go code.
+package workload
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ColumnType is the column's SQL-ish type, mapped to a dialect-specific
+// SQL type name at DDL-generation time.
+type ColumnType string
+
+const (
+	ColumnText    ColumnType = "text"
+	ColumnInt     ColumnType = "int"
+	ColumnDecimal ColumnType = "decimal"
+	// ColumnPKey and ColumnFKey behave like ColumnInt but additionally
+	// mark the column as part of the table's primary key (ColumnFKey
+	// columns participate in both a foreign key and the primary key, the
+	// same composite-PK layout the original hardcoded schema used).
+	ColumnPKey ColumnType = "pkey"
+	ColumnFKey ColumnType = "fkey"
+)
+
+// Generator names how a column's values are produced. Only Uniform is
+// implemented today; Zipf, Sequential, and Text are reserved for a
+// TPC-C-like skewed workload but aren't wired up yet, so Load rejects
+// any spec that declares them rather than silently generating uniform
+// data in their place.
+type Generator string
+
+const (
+	GeneratorUniform    Generator = "uniform"
+	GeneratorZipf       Generator = "zipf"
+	GeneratorSequential Generator = "sequential"
+	GeneratorText       Generator = "text-length"
+)
+
+// Column describes one table column: its SQL type, how its values are
+// generated, and -- for ColumnFKey columns -- which table's primary key
+// it draws its value from.
+type Column struct {
+	Name       string     `yaml:"name"`
+	Type       ColumnType `yaml:"type"`
+	Generator  Generator  `yaml:"generator,omitempty"`
+	TextLength int        `yaml:"text_length,omitempty"`
+	// References names the table a ColumnFKey column's values are drawn
+	// from (e.g. "merchant" for variant.v_m_id).
+	References string `yaml:"references,omitempty"`
+}
+
+// Interleave declares that a table should be interleaved in its parent's
+// index, CockroachDB-style, when --variant=interleaved.
+type Interleave struct {
+	Parent  string   `yaml:"parent"`
+	Columns []string `yaml:"columns"`
+}
+
+// Table describes one table in the schema: its columns, its row count
+// for --load, and its optional interleave parent.
+type Table struct {
+	Name       string      `yaml:"name"`
+	Rows       int         `yaml:"rows"`
+	Columns    []Column    `yaml:"columns"`
+	Interleave *Interleave `yaml:"interleave,omitempty"`
+}
+
+// Query is one write the worker loop can issue against Table, weighted
+// against the spec's other queries to form the run's workload mix.
+type Query struct {
+	Name   string  `yaml:"name"`
+	Table  string  `yaml:"table"`
+	Weight float64 `yaml:"weight"`
+}
+
+// Spec is a full workload definition: the schema (Tables) and the
+// weighted write mix the worker loop runs (Queries). Every query inserts
+// one generated row into its Table; reads and custom statements are not
+// supported.
+type Spec struct {
+	Name    string  `yaml:"name"`
+	Tables  []Table `yaml:"tables"`
+	Queries []Query `yaml:"queries"`
+}
+
+// Load reads and parses a workload spec from path.
+func Load(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading workload spec")
+	}
+
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, errors.Wrap(err, "parsing workload spec")
+	}
+
+	if err := validateGenerators(&spec); err != nil {
+		return nil, err
+	}
+	if len(spec.Queries) == 0 {
+		return nil, errors.New("workload spec declares no queries")
+	}
+
+	return &spec, nil
+}
+
+// validateGenerators rejects a spec that declares a Generator other than
+// GeneratorUniform (the empty default included), since Zipf, Sequential,
+// and Text aren't implemented -- silently falling back to uniform data
+// would quietly defeat a spec's declared intent (e.g. modeling skew).
+func validateGenerators(spec *Spec) error {
+	for _, t := range spec.Tables {
+		for _, c := range t.Columns {
+			switch c.Generator {
+			case "", GeneratorUniform:
+			default:
+				return errors.Errorf("table %q column %q: generator %q is not implemented (only %q is supported)",
+					t.Name, c.Name, c.Generator, GeneratorUniform)
+			}
+		}
+	}
+	return nil
+}